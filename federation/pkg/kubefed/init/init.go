@@ -15,24 +15,25 @@ limitations under the License.
 */
 
 // TODO(madhusdancs):
-// 1. Make printSuccess prepend protocol/scheme to the IPs/hostnames.
 // 1. Add a dry-run support.
 // 2. Make all the API object names customizable.
 //    Ex: federation-apiserver, federation-controller-manager, etc.
 // 3. Make image name and tag customizable.
-// 4. Separate etcd container from API server pod as a first step towards enabling HA.
-// 5. Generate credentials of the following types for the API server:
-//    i.  "known_tokens.csv"
-//    ii. "basic_auth.csv"
 // 6. Add the ability to customize DNS domain suffix. It should probably be derived
 //    from cluster config.
 // 7. Make etcd PVC size configurable.
-// 8. Make API server and controller manager replicas customizable via the HA work.
 package init
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -47,6 +48,7 @@ import (
 	kubeadmkubeconfigphase "k8s.io/kubernetes/cmd/kubeadm/app/phases/kubeconfig"
 	"k8s.io/kubernetes/federation/pkg/kubefed/util"
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/apps"
 	"k8s.io/kubernetes/pkg/apis/extensions"
 	"k8s.io/kubernetes/pkg/apis/rbac"
 	client "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
@@ -71,11 +73,27 @@ const (
 	// to access the secrets in the host cluster.
 	ControllerManagerSA = "federation-controller-manager"
 
+	// User name for the bearer token handed out so that `kubefed join`
+	// can authenticate against the federation apiserver without the
+	// caller needing access to the CA key.
+	JoinTokenUser = "federation-join"
+
 	// Group name of the legacy/core API group
 	legacyAPIGroup = ""
 
 	lbAddrRetryInterval = 5 * time.Second
 	podWaitInterval     = 2 * time.Second
+
+	// Default number of replicas for the federation etcd cluster used
+	// when standing up a highly available control plane.
+	defaultEtcdReplicas = 3
+
+	// Default image for the datastore shim sidecar that speaks etcd v3
+	// gRPC on behalf of a SQL-backed --datastore-endpoint.
+	defaultDatastoreShimImage = "gcr.io/google_containers/kine-shim-amd64:latest"
+
+	datastoreCredentialsVolumeName = "datastore-credentials"
+	datastoreCredentialsMountPath  = "/etc/federation/datastore"
 )
 
 var (
@@ -110,11 +128,40 @@ var (
 		"module": "federation-controller-manager",
 	}
 
+	etcdSvcSelector = map[string]string{
+		"app":    "federated-cluster",
+		"module": "federation-etcd",
+	}
+
+	etcdPodLabels = map[string]string{
+		"app":    "federated-cluster",
+		"module": "federation-etcd",
+	}
+
 	hyperkubeImageName = "gcr.io/google_containers/hyperkube-amd64"
 )
 
 // NewCmdInit defines the `init` command that bootstraps a federation
 // control plane inside a set of host clusters.
+// ServerName returns the name of the federation apiserver Deployment and
+// Service for the federation named name.
+func ServerName(name string) string {
+	return fmt.Sprintf("%s-apiserver", name)
+}
+
+// CredentialsSecretName returns the name of the secret holding the
+// federation apiserver's TLS and auth-file credentials.
+func CredentialsSecretName(name string) string {
+	return fmt.Sprintf("%s-credentials", ServerName(name))
+}
+
+// CASecretName returns the name of the secret holding the federation's
+// persisted CA key pair, used by `kubefed rotate-certs` to re-sign the
+// apiserver's server certificate without minting a new CA.
+func CASecretName(name string) string {
+	return fmt.Sprintf("%s-ca", name)
+}
+
 func NewCmdInit(cmdOut io.Writer, config util.AdminConfig) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:     "init FEDERATION_NAME --host-cluster-context=HOST_CONTEXT",
@@ -137,6 +184,25 @@ func NewCmdInit(cmdOut io.Writer, config util.AdminConfig) *cobra.Command {
 	cmd.Flags().Bool("etcd-persistent-storage", true, "Use persistent volume for etcd. Defaults to 'true'.")
 	cmd.Flags().Bool("dry-run", false, "dry run without sending commands to server.")
 	cmd.Flags().String("storage-backend", "etcd2", "The storage backend for persistence. Options: 'etcd2' (default), 'etcd3'.")
+	cmd.Flags().Int("apiserver-replicas", 1, "Number of replicas of the federation apiserver to run for high availability.")
+	cmd.Flags().Int("controller-manager-replicas", 1, "Number of replicas of the federation controller manager to run for high availability.")
+	cmd.Flags().Int("etcd-replicas", defaultEtcdReplicas, "Number of replicas of the federation etcd cluster to run for high availability. Only takes effect when etcd-persistent-storage is true.")
+	cmd.Flags().String("controller-manager-lease-namespace", "", "Namespace in which the federation controller manager acquires its leader election lease. Defaults to the federation system namespace.")
+	cmd.Flags().String("datastore-endpoint", "", "URI of the datastore backing the federation apiserver, e.g. mysql://..., postgres://..., sqlite://... or http(s)://etcd-endpoint:2379. When set to a non-etcd scheme, a datastore shim sidecar is used in place of the embedded etcd cluster.")
+	cmd.Flags().String("datastore-shim-image", defaultDatastoreShimImage, "Image of the datastore shim sidecar that translates etcd v3 gRPC calls to the SQL datastore named by --datastore-endpoint.")
+	cmd.Flags().String("datastore-cafile", "", "Path to a CA certificate file used to verify the --datastore-endpoint, mounted into the datastore shim sidecar via a secret.")
+	cmd.Flags().String("datastore-certfile", "", "Path to a client certificate file used to authenticate to --datastore-endpoint, mounted into the datastore shim sidecar via a secret.")
+	cmd.Flags().String("datastore-keyfile", "", "Path to a client key file used to authenticate to --datastore-endpoint, mounted into the datastore shim sidecar via a secret.")
+	cmd.Flags().StringSlice("extra-token", []string{}, "Additional 'user:group1,group2' entries to add to the federation apiserver's known_tokens.csv. May be repeated.")
+	cmd.Flags().String("auth-mode", "cert", "Authentication method written into the admin entry of the kubeconfig generated by init. One of 'cert', 'token' or 'basic'.")
+	cmd.Flags().Duration("cert-validity", 365*24*time.Hour, "Interval after which `kubefed rotate-certs` should proactively re-sign the federation apiserver's server certificate, even if its SANs are still current.")
+	cmd.Flags().String("api-server-service-type", string(api.ServiceTypeLoadBalancer), "The type of service to create for the federation API server. Options: 'LoadBalancer' (default), 'NodePort', 'ClusterIP'. Use 'NodePort' for host clusters without a cloud LoadBalancer controller.")
+	cmd.Flags().Int("api-server-node-port", 0, "Manually specify the NodePort to use when --api-server-service-type is 'NodePort'. Defaults to a value assigned by the host cluster.")
+	cmd.Flags().String("api-server-advertise-address", "", "Comma-separated IPs/hostnames the federation apiserver should be reached at, e.g. for a manually load-balanced or externally addressed host cluster. When set, the federation Service's address is never polled for.")
+	cmd.Flags().StringSlice("disable-controllers", []string{}, "Comma-separated list of federation controllers to disable, e.g. 'service-dns,ingress,replicaset'. Translated into negative entries on the controller manager's --controllers flag.")
+	cmd.Flags().Bool("disable-controller-manager", false, "Don't create the federation controller manager at all, e.g. to run it out-of-cluster or replace it with a custom implementation. Only the federation-apiserver is created.")
+	cmd.Flags().StringSlice("extra-apiserver-arg", []string{}, "Extra 'key=value' flags to append to the federation-apiserver command. May be repeated.")
+	cmd.Flags().StringSlice("extra-controller-manager-arg", []string{}, "Extra 'key=value' flags to append to the federation-controller-manager command. May be repeated.")
 	return cmd
 }
 
@@ -147,6 +213,104 @@ type entityKeyPairs struct {
 	admin             *triple.KeyPair
 }
 
+// authFiles holds the token and basic-auth credential files mounted into
+// the federation apiserver so that it can be reached with
+// --token-auth-file/--basic-auth-file authentication in addition to
+// client certificates.
+type authFiles struct {
+	knownTokens []byte
+	basicAuth   []byte
+	joinToken   string
+
+	// adminToken and adminPassword are the admin principal's generated
+	// credentials, used to populate the kubeconfig when --auth-mode is
+	// "token" or "basic".
+	adminToken    string
+	adminPassword string
+}
+
+type authPrincipal struct {
+	user   string
+	uid    string
+	groups string
+}
+
+// genAuthFiles builds known_tokens.csv and basic_auth.csv contents for
+// admin, the federation controller manager, and any --extra-token
+// entries, plus a dedicated bearer token for JoinTokenUser so that
+// `kubefed join` can authenticate without the CA key.
+func genAuthFiles(extraTokens []string) (*authFiles, error) {
+	principals := []authPrincipal{
+		{user: AdminCN, uid: "admin"},
+		{user: ControllerManagerCN, uid: "controller-manager"},
+	}
+	for _, extra := range extraTokens {
+		user, groups := extra, ""
+		if idx := strings.Index(extra, ":"); idx >= 0 {
+			user, groups = extra[:idx], extra[idx+1:]
+		}
+		principals = append(principals, authPrincipal{user: user, uid: user, groups: groups})
+	}
+
+	var knownTokens, basicAuth bytes.Buffer
+	var adminToken, adminPassword string
+	for _, p := range principals {
+		token, err := randomHexToken(32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate token for %q: %v", p.user, err)
+		}
+		line := fmt.Sprintf("%s,%s,%s", token, p.user, p.uid)
+		if p.groups != "" {
+			line = fmt.Sprintf("%s,%q", line, p.groups)
+		}
+		knownTokens.WriteString(line + "\n")
+
+		password, err := randomHexToken(16)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate password for %q: %v", p.user, err)
+		}
+		basicAuth.WriteString(fmt.Sprintf("%s,%s,%s\n", password, p.user, p.uid))
+
+		if p.user == AdminCN {
+			adminToken, adminPassword = token, password
+		}
+	}
+
+	joinToken, err := randomHexToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate join token: %v", err)
+	}
+	knownTokens.WriteString(fmt.Sprintf("%s,%s,%s\n", joinToken, JoinTokenUser, JoinTokenUser))
+
+	return &authFiles{
+		knownTokens:   knownTokens.Bytes(),
+		basicAuth:     basicAuth.Bytes(),
+		joinToken:     joinToken,
+		adminToken:    adminToken,
+		adminPassword: adminPassword,
+	}, nil
+}
+
+// extraArgsToFlags turns "key=value" entries from a repeatable
+// --extra-*-arg flag into "--key=value" command-line flags, so operators
+// can tune admission plugins, audit logging and feature gates without
+// code changes.
+func extraArgsToFlags(extraArgs []string) []string {
+	flags := make([]string, 0, len(extraArgs))
+	for _, arg := range extraArgs {
+		flags = append(flags, fmt.Sprintf("--%s", arg))
+	}
+	return flags
+}
+
+func randomHexToken(numBytes int) (string, error) {
+	b := make([]byte, numBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // initFederation initializes a federation control plane.
 // See the design doc in https://github.com/kubernetes/kubernetes/pull/34484
 // for details.
@@ -162,6 +326,53 @@ func initFederation(cmdOut io.Writer, config util.AdminConfig, cmd *cobra.Comman
 	etcdPersistence := cmdutil.GetFlagBool(cmd, "etcd-persistent-storage")
 	dryRun := cmdutil.GetDryRunFlag(cmd)
 	storageBackend := cmdutil.GetFlagString(cmd, "storage-backend")
+	apiserverReplicas := int32(cmdutil.GetFlagInt(cmd, "apiserver-replicas"))
+	controllerManagerReplicas := int32(cmdutil.GetFlagInt(cmd, "controller-manager-replicas"))
+	etcdReplicas := int32(cmdutil.GetFlagInt(cmd, "etcd-replicas"))
+	leaseNamespace := cmdutil.GetFlagString(cmd, "controller-manager-lease-namespace")
+	if leaseNamespace == "" {
+		leaseNamespace = initFlags.FederationSystemNamespace
+	}
+	datastoreEndpoint := cmdutil.GetFlagString(cmd, "datastore-endpoint")
+	datastoreShimImage := cmdutil.GetFlagString(cmd, "datastore-shim-image")
+	datastoreCAFile := cmdutil.GetFlagString(cmd, "datastore-cafile")
+	datastoreCertFile := cmdutil.GetFlagString(cmd, "datastore-certfile")
+	datastoreKeyFile := cmdutil.GetFlagString(cmd, "datastore-keyfile")
+	extraTokens := cmdutil.GetFlagStringSlice(cmd, "extra-token")
+	authMode := cmdutil.GetFlagString(cmd, "auth-mode")
+	certValidity := cmdutil.GetFlagDuration(cmd, "cert-validity")
+	switch authMode {
+	case "cert", "token", "basic":
+	default:
+		return fmt.Errorf("invalid --auth-mode %q: must be one of cert, token, basic", authMode)
+	}
+	apiServerServiceType := api.ServiceType(cmdutil.GetFlagString(cmd, "api-server-service-type"))
+	apiServerNodePort := int32(cmdutil.GetFlagInt(cmd, "api-server-node-port"))
+	apiServerAdvertiseAddress := cmdutil.GetFlagString(cmd, "api-server-advertise-address")
+	switch apiServerServiceType {
+	case api.ServiceTypeLoadBalancer, api.ServiceTypeNodePort, api.ServiceTypeClusterIP:
+	default:
+		return fmt.Errorf("invalid --api-server-service-type %q: must be one of LoadBalancer, NodePort, ClusterIP", apiServerServiceType)
+	}
+	disableControllers := cmdutil.GetFlagStringSlice(cmd, "disable-controllers")
+	disableControllerManager := cmdutil.GetFlagBool(cmd, "disable-controller-manager")
+	extraAPIServerArgs := extraArgsToFlags(cmdutil.GetFlagStringSlice(cmd, "extra-apiserver-arg"))
+	extraControllerManagerArgs := extraArgsToFlags(cmdutil.GetFlagStringSlice(cmd, "extra-controller-manager-arg"))
+
+	// A raw etcd --datastore-endpoint (http/https scheme) is passed straight
+	// through to --etcd-servers. Any other scheme (mysql, postgres, sqlite,
+	// ...) is fronted by a datastore shim sidecar speaking etcd v3 gRPC on
+	// 127.0.0.1:2379, so the embedded etcd StatefulSet/PVC is never created.
+	useExternalDatastore := datastoreEndpoint != ""
+	isRawEtcdEndpoint := false
+	if useExternalDatastore {
+		u, err := url.Parse(datastoreEndpoint)
+		if err != nil {
+			return fmt.Errorf("invalid --datastore-endpoint %q: %v", datastoreEndpoint, err)
+		}
+		isRawEtcdEndpoint = u.Scheme == "http" || u.Scheme == "https"
+	}
+	useEmbeddedEtcdCluster := etcdPersistence && !useExternalDatastore
 
 	hostFactory := config.HostFactory(initFlags.Host, initFlags.Kubeconfig)
 	hostClientset, err := hostFactory.ClientSet()
@@ -169,10 +380,12 @@ func initFederation(cmdOut io.Writer, config util.AdminConfig, cmd *cobra.Comman
 		return err
 	}
 
-	serverName := fmt.Sprintf("%s-apiserver", initFlags.Name)
-	serverCredName := fmt.Sprintf("%s-credentials", serverName)
+	serverName := ServerName(initFlags.Name)
+	serverCredName := CredentialsSecretName(initFlags.Name)
 	cmName := fmt.Sprintf("%s-controller-manager", initFlags.Name)
 	cmKubeconfigName := fmt.Sprintf("%s-kubeconfig", cmName)
+	etcdName := fmt.Sprintf("%s-etcd", serverName)
+	caSecretName := CASecretName(initFlags.Name)
 
 	// 1. Create a namespace for federation system components
 	_, err = createNamespace(hostClientset, initFlags.FederationSystemNamespace, dryRun)
@@ -181,22 +394,72 @@ func initFederation(cmdOut io.Writer, config util.AdminConfig, cmd *cobra.Comman
 	}
 
 	// 2. Expose a network endpoint for the federation API server
-	svc, err := createService(hostClientset, initFlags.FederationSystemNamespace, serverName, dryRun)
+	svc, err := createService(hostClientset, initFlags.FederationSystemNamespace, serverName, apiServerServiceType, apiServerNodePort, dryRun)
+	if err != nil {
+		return err
+	}
+
+	var ips, hostnames []string
+	switch {
+	case apiServerAdvertiseAddress != "":
+		// The caller already knows how the apiserver will be reached, e.g.
+		// a manually configured external LB in front of a bare-metal host
+		// cluster, so skip address discovery entirely.
+		ips, hostnames = splitAdvertiseAddress(apiServerAdvertiseAddress)
+	case apiServerServiceType == api.ServiceTypeNodePort:
+		ips, hostnames, err = waitForNodeAddresses(hostClientset, dryRun)
+	case apiServerServiceType == api.ServiceTypeClusterIP:
+		ips, hostnames = clusterIPAddress(svc)
+	default:
+		ips, hostnames, err = waitForLoadBalancerAddress(hostClientset, svc, dryRun)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The host cluster assigns the NodePort when --api-server-node-port is
+	// left at its default of 0, so read the actual port back off the
+	// created Service rather than trusting the flag value callers used.
+	if apiServerServiceType == api.ServiceTypeNodePort && apiServerNodePort == 0 && !dryRun {
+		apiServerNodePort = svc.Spec.Ports[0].NodePort
+	}
+
+	// 2a. Expose a headless service for the etcd StatefulSet so each
+	// member gets a predictable DNS name to peer and be reached on. Not
+	// needed when an external datastore is in play.
+	var etcdPeerHostnames []string
+	if useEmbeddedEtcdCluster {
+		etcdSvc, err := createEtcdService(hostClientset, initFlags.FederationSystemNamespace, etcdName, dryRun)
+		if err != nil {
+			return err
+		}
+		etcdPeerHostnames = etcdPodDNSNames(etcdSvc.Name, initFlags.FederationSystemNamespace, HostClusterLocalDNSZoneName, etcdReplicas)
+	}
+
+	// 3. Generate TLS certificates and credentials. The apiserver itself
+	// stays a plain Deployment rather than a StatefulSet, so, unlike etcd,
+	// its pods have no stable per-pod DNS name to add as a SAN; clients
+	// only ever reach it through svc.Name or the discovered ips/hostnames.
+	entKeyPairs, err := genCerts(initFlags.FederationSystemNamespace, initFlags.Name, svc.Name, HostClusterLocalDNSZoneName, ips, append(append([]string{}, hostnames...), etcdPeerHostnames...))
 	if err != nil {
 		return err
 	}
-	ips, hostnames, err := waitForLoadBalancerAddress(hostClientset, svc, dryRun)
+
+	auth, err := genAuthFiles(extraTokens)
 	if err != nil {
 		return err
 	}
 
-	// 3. Generate TLS certificates and credentials
-	entKeyPairs, err := genCerts(initFlags.FederationSystemNamespace, initFlags.Name, svc.Name, HostClusterLocalDNSZoneName, ips, hostnames)
+	_, err = createAPIServerCredentialsSecret(hostClientset, initFlags.FederationSystemNamespace, serverCredName, entKeyPairs, auth, ips, hostnames, dryRun)
 	if err != nil {
 		return err
 	}
 
-	_, err = createAPIServerCredentialsSecret(hostClientset, initFlags.FederationSystemNamespace, serverCredName, entKeyPairs, dryRun)
+	// 3a. Persist the CA key pair separately from the server credentials so
+	// that `kubefed rotate-certs` can re-sign the server certificate, e.g.
+	// after the LoadBalancer address changes, without minting a new CA and
+	// invalidating every existing client certificate.
+	_, err = createCACredentialsSecret(hostClientset, initFlags.FederationSystemNamespace, caSecretName, entKeyPairs, certValidity, dryRun)
 	if err != nil {
 		return err
 	}
@@ -207,15 +470,65 @@ func initFederation(cmdOut io.Writer, config util.AdminConfig, cmd *cobra.Comman
 		return err
 	}
 
-	// 5. Create a persistent volume and a claim to store the federation
-	// API server's state. This is where federation API server's etcd
-	// stores its data.
-	var pvc *api.PersistentVolumeClaim
-	if etcdPersistence {
-		pvc, err = createPVC(hostClientset, initFlags.FederationSystemNamespace, svc.Name, etcdPVCapacity, dryRun)
+	// 5. Wire up the datastore backing the federation API server: a
+	// dedicated etcd StatefulSet (each member gets its own PVC, sized from
+	// --etcd-pv-capacity), a raw external etcd endpoint, or a SQL datastore
+	// fronted by a local shim sidecar.
+	etcdServers := []string{}
+	var datastoreSidecar *api.Container
+	var datastoreSidecarVolume *api.Volume
+	switch {
+	case useExternalDatastore && isRawEtcdEndpoint:
+		etcdServers = []string{datastoreEndpoint}
+	case useExternalDatastore:
+		etcdServers = []string{"http://127.0.0.1:2379"}
+		shimCommand := []string{
+			"/datastore-shim",
+			"--listen-address=127.0.0.1:2379",
+			fmt.Sprintf("--datastore-endpoint=%s", datastoreEndpoint),
+		}
+		var credsSecretName string
+		if datastoreCAFile != "" || datastoreCertFile != "" || datastoreKeyFile != "" {
+			credsSecretName = fmt.Sprintf("%s-datastore-credentials", serverName)
+			_, err = createDatastoreCredentialsSecret(hostClientset, initFlags.FederationSystemNamespace, credsSecretName, datastoreCAFile, datastoreCertFile, datastoreKeyFile, dryRun)
+			if err != nil {
+				return err
+			}
+			shimCommand = append(shimCommand,
+				fmt.Sprintf("--cacert=%s/ca.crt", datastoreCredentialsMountPath),
+				fmt.Sprintf("--cert=%s/client.crt", datastoreCredentialsMountPath),
+				fmt.Sprintf("--key=%s/client.key", datastoreCredentialsMountPath))
+		}
+		datastoreSidecar = &api.Container{
+			Name:    "datastore-shim",
+			Image:   datastoreShimImage,
+			Command: shimCommand,
+		}
+		if credsSecretName != "" {
+			datastoreSidecar.VolumeMounts = []api.VolumeMount{
+				{
+					Name:      datastoreCredentialsVolumeName,
+					MountPath: datastoreCredentialsMountPath,
+					ReadOnly:  true,
+				},
+			}
+			datastoreSidecarVolume = &api.Volume{
+				Name: datastoreCredentialsVolumeName,
+				VolumeSource: api.VolumeSource{
+					Secret: &api.SecretVolumeSource{
+						SecretName: credsSecretName,
+					},
+				},
+			}
+		}
+	case useEmbeddedEtcdCluster:
+		_, err = createEtcdCluster(hostClientset, initFlags.FederationSystemNamespace, etcdName, etcdPVCapacity, etcdReplicas, dryRun)
 		if err != nil {
 			return err
 		}
+		for i := int32(0); i < etcdReplicas; i++ {
+			etcdServers = append(etcdServers, fmt.Sprintf("http://%s-%d.%s.%s.svc.%s:2379", etcdName, i, etcdName, initFlags.FederationSystemNamespace, HostClusterLocalDNSZoneName))
+		}
 	}
 
 	// Since only one IP address can be specified as advertise address,
@@ -229,44 +542,55 @@ func initFederation(cmdOut io.Writer, config util.AdminConfig, cmd *cobra.Comman
 	if advertiseAddress == "" && len(hostnames) > 0 {
 		endpoint = hostnames[0]
 	}
+	if apiServerServiceType == api.ServiceTypeNodePort && apiServerNodePort != 0 {
+		endpoint = fmt.Sprintf("%s:%d", endpoint, apiServerNodePort)
+	}
 
 	// 6. Create federation API server
-	_, err = createAPIServer(hostClientset, initFlags.FederationSystemNamespace, serverName, image, serverCredName, advertiseAddress, storageBackend, pvc, dryRun)
+	_, err = createAPIServer(hostClientset, initFlags.FederationSystemNamespace, serverName, image, serverCredName, advertiseAddress, storageBackend, etcdServers, datastoreSidecar, datastoreSidecarVolume, extraAPIServerArgs, apiserverReplicas, dryRun)
 	if err != nil {
 		return err
 	}
 
-	// 7. Create federation controller manager
-	// 7a. Create a service account in the host cluster for federation
-	// controller manager.
-	sa, err := createControllerManagerSA(hostClientset, initFlags.FederationSystemNamespace, dryRun)
-	if err != nil {
-		return err
-	}
+	// 7. Create federation controller manager, unless the caller wants to
+	// run their own out-of-cluster instead.
+	fedComponents := []podComponent{{name: serverName, replicas: apiserverReplicas}}
+	if !disableControllerManager {
+		// 7a. Create a service account in the host cluster for federation
+		// controller manager.
+		sa, err := createControllerManagerSA(hostClientset, initFlags.FederationSystemNamespace, dryRun)
+		if err != nil {
+			return err
+		}
 
-	// 7b. Create RBAC role and role binding for federation controller
-	// manager service account.
-	_, _, err = createRoleBindings(hostClientset, initFlags.FederationSystemNamespace, sa.Name, dryRun)
-	if err != nil {
-		return err
-	}
+		// 7b. Create RBAC role and role binding for federation controller
+		// manager service account.
+		_, _, err = createRoleBindings(hostClientset, initFlags.FederationSystemNamespace, sa.Name, dryRun)
+		if err != nil {
+			return err
+		}
 
-	// 7c. Create federation controller manager deployment.
-	_, err = createControllerManager(hostClientset, initFlags.FederationSystemNamespace, initFlags.Name, svc.Name, cmName, image, cmKubeconfigName, dnsZoneName, dnsProvider, sa.Name, dryRun)
-	if err != nil {
-		return err
+		// 7c. Create federation controller manager deployment.
+		_, err = createControllerManager(hostClientset, initFlags.FederationSystemNamespace, initFlags.Name, svc.Name, cmName, image, cmKubeconfigName, dnsZoneName, dnsProvider, sa.Name, leaseNamespace, disableControllers, extraControllerManagerArgs, controllerManagerReplicas, dryRun)
+		if err != nil {
+			return err
+		}
+		fedComponents = append(fedComponents, podComponent{name: cmName, replicas: controllerManagerReplicas})
 	}
 
 	// 8. Write the federation API server endpoint info, credentials
 	// and context to kubeconfig
-	err = updateKubeconfig(config, initFlags.Name, endpoint, entKeyPairs, dryRun)
+	err = updateKubeconfig(config, initFlags.Name, endpoint, entKeyPairs, auth, authMode, dryRun)
 	if err != nil {
 		return err
 	}
 
 	if !dryRun {
-		fedPods := []string{serverName, cmName}
-		err = waitForPods(hostClientset, fedPods, initFlags.FederationSystemNamespace)
+		etcdQuorum := int32(0)
+		if useEmbeddedEtcdCluster {
+			etcdQuorum = etcdReplicas/2 + 1
+		}
+		err = waitForPods(hostClientset, fedComponents, initFlags.FederationSystemNamespace, etcdName, etcdQuorum)
 		if err != nil {
 			return err
 		}
@@ -274,7 +598,7 @@ func initFederation(cmdOut io.Writer, config util.AdminConfig, cmd *cobra.Comman
 		if err != nil {
 			return err
 		}
-		return printSuccess(cmdOut, ips, hostnames)
+		return printSuccess(cmdOut, ips, hostnames, apiServerServiceType, apiServerNodePort, auth.joinToken)
 	}
 	_, err = fmt.Fprintf(cmdOut, "Federation control plane runs (dry run)\n")
 	return err
@@ -294,7 +618,17 @@ func createNamespace(clientset *client.Clientset, namespace string, dryRun bool)
 	return clientset.Core().Namespaces().Create(ns)
 }
 
-func createService(clientset *client.Clientset, namespace, svcName string, dryRun bool) (*api.Service, error) {
+func createService(clientset *client.Clientset, namespace, svcName string, serviceType api.ServiceType, nodePort int32, dryRun bool) (*api.Service, error) {
+	port := api.ServicePort{
+		Name:       "https",
+		Protocol:   "TCP",
+		Port:       443,
+		TargetPort: intstr.FromInt(443),
+	}
+	if serviceType == api.ServiceTypeNodePort && nodePort != 0 {
+		port.NodePort = nodePort
+	}
+
 	svc := &api.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      svcName,
@@ -302,16 +636,9 @@ func createService(clientset *client.Clientset, namespace, svcName string, dryRu
 			Labels:    componentLabel,
 		},
 		Spec: api.ServiceSpec{
-			Type:     api.ServiceTypeLoadBalancer,
+			Type:     serviceType,
 			Selector: apiserverSvcSelector,
-			Ports: []api.ServicePort{
-				{
-					Name:       "https",
-					Protocol:   "TCP",
-					Port:       443,
-					TargetPort: intstr.FromInt(443),
-				},
-			},
+			Ports:    []api.ServicePort{port},
 		},
 	}
 
@@ -357,6 +684,90 @@ func waitForLoadBalancerAddress(clientset *client.Clientset, svc *api.Service, d
 	return ips, hostnames, nil
 }
 
+// waitForNodeAddresses discovers addresses to reach the federation
+// apiserver's NodePort Service on, for host clusters without a cloud
+// LoadBalancer controller. It prefers each node's ExternalIP, falling
+// back to its InternalIP, and polls until the host cluster reports at
+// least one node with an address.
+func waitForNodeAddresses(clientset *client.Clientset, dryRun bool) ([]string, []string, error) {
+	ips := []string{}
+	hostnames := []string{}
+
+	if dryRun {
+		return ips, hostnames, nil
+	}
+
+	err := wait.PollImmediateInfinite(lbAddrRetryInterval, func() (bool, error) {
+		nodes, err := clientset.Core().Nodes().List(metav1.ListOptions{})
+		if err != nil {
+			return false, nil
+		}
+		var addresses []string
+		for _, node := range nodes.Items {
+			if address := nodeExternalOrInternalIP(&node); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+		if len(addresses) == 0 {
+			return false, nil
+		}
+		ips = addresses
+		return true, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ips, hostnames, nil
+}
+
+// clusterIPAddress returns the federation apiserver Service's ClusterIP,
+// for host clusters where the apiserver is only meant to be reached from
+// inside the cluster and neither a cloud LoadBalancer nor NodePort
+// routing applies. Unlike a LoadBalancer address, the ClusterIP is
+// already populated on svc as returned by createService, so no polling
+// is needed.
+func clusterIPAddress(svc *api.Service) (ips, hostnames []string) {
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == api.ClusterIPNone {
+		return nil, nil
+	}
+	return []string{svc.Spec.ClusterIP}, nil
+}
+
+// nodeExternalOrInternalIP returns a node's ExternalIP, or its
+// InternalIP if it has no ExternalIP.
+func nodeExternalOrInternalIP(node *api.Node) string {
+	var internalIP string
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == api.NodeExternalIP {
+			return addr.Address
+		}
+		if addr.Type == api.NodeInternalIP {
+			internalIP = addr.Address
+		}
+	}
+	return internalIP
+}
+
+// splitAdvertiseAddress classifies each comma-separated entry of
+// --api-server-advertise-address as an IP or a hostname, so a manually
+// supplied address can be used directly as certificate SANs and the
+// kubeconfig server endpoint without any address discovery.
+func splitAdvertiseAddress(advertiseAddress string) (ips, hostnames []string) {
+	for _, addr := range strings.Split(advertiseAddress, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if net.ParseIP(addr) != nil {
+			ips = append(ips, addr)
+		} else {
+			hostnames = append(hostnames, addr)
+		}
+	}
+	return ips, hostnames
+}
+
 func genCerts(svcNamespace, name, svcName, localDNSZoneName string, ips, hostnames []string) (*entityKeyPairs, error) {
 	ca, err := triple.NewCA(name)
 	if err != nil {
@@ -382,17 +793,45 @@ func genCerts(svcNamespace, name, svcName, localDNSZoneName string, ips, hostnam
 	}, nil
 }
 
-func createAPIServerCredentialsSecret(clientset *client.Clientset, namespace, credentialsName string, entKeyPairs *entityKeyPairs, dryRun bool) (*api.Secret, error) {
+// ApiserverSANsAnnotation records the sorted set of IPs/hostnames the
+// current server certificate was signed for, so that `kubefed
+// rotate-certs` can detect when the federation Service's LoadBalancer
+// address has drifted from what's in the certificate.
+const ApiserverSANsAnnotation = "federation.alpha.kubernetes.io/apiserver-sans"
+
+// JoinSANs canonicalizes a certificate's IP/hostname SANs into a single
+// sorted, comma-separated string suitable for storing in and comparing
+// against ApiserverSANsAnnotation.
+func JoinSANs(ips, hostnames []string) string {
+	sans := append(append([]string{}, ips...), hostnames...)
+	sort.Strings(sans)
+	return strings.Join(sans, ",")
+}
+
+// ServerCertSignedAtAnnotation records when the server certificate
+// currently in the credentials secret was (re)signed, in RFC 3339 form,
+// so that `kubefed rotate-certs` can tell whether --cert-validity has
+// elapsed since and proactively re-sign even when the SANs haven't
+// changed.
+const ServerCertSignedAtAnnotation = "federation.alpha.kubernetes.io/server-cert-signed-at"
+
+func createAPIServerCredentialsSecret(clientset *client.Clientset, namespace, credentialsName string, entKeyPairs *entityKeyPairs, auth *authFiles, ips, hostnames []string, dryRun bool) (*api.Secret, error) {
 	// Build the secret object with API server credentials.
 	secret := &api.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      credentialsName,
 			Namespace: namespace,
+			Annotations: map[string]string{
+				ApiserverSANsAnnotation:      JoinSANs(ips, hostnames),
+				ServerCertSignedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+			},
 		},
 		Data: map[string][]byte{
-			"ca.crt":     certutil.EncodeCertPEM(entKeyPairs.ca.Cert),
-			"server.crt": certutil.EncodeCertPEM(entKeyPairs.server.Cert),
-			"server.key": certutil.EncodePrivateKeyPEM(entKeyPairs.server.Key),
+			"ca.crt":           certutil.EncodeCertPEM(entKeyPairs.ca.Cert),
+			"server.crt":       certutil.EncodeCertPEM(entKeyPairs.server.Cert),
+			"server.key":       certutil.EncodePrivateKeyPEM(entKeyPairs.server.Key),
+			"known_tokens.csv": auth.knownTokens,
+			"basic_auth.csv":   auth.basicAuth,
 		},
 	}
 
@@ -403,6 +842,65 @@ func createAPIServerCredentialsSecret(clientset *client.Clientset, namespace, cr
 	return clientset.Core().Secrets(namespace).Create(secret)
 }
 
+// CertValidityAnnotation records the requested --cert-validity period on
+// the CA secret so that `kubefed rotate-certs` knows how often a signed
+// server certificate should be proactively replaced.
+const CertValidityAnnotation = "federation.alpha.kubernetes.io/cert-validity"
+
+func createCACredentialsSecret(clientset *client.Clientset, namespace, caSecretName string, entKeyPairs *entityKeyPairs, certValidity time.Duration, dryRun bool) (*api.Secret, error) {
+	secret := &api.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      caSecretName,
+			Namespace: namespace,
+			Labels:    componentLabel,
+			Annotations: map[string]string{
+				CertValidityAnnotation: certValidity.String(),
+			},
+		},
+		Data: map[string][]byte{
+			"ca.crt": certutil.EncodeCertPEM(entKeyPairs.ca.Cert),
+			"ca.key": certutil.EncodePrivateKeyPEM(entKeyPairs.ca.Key),
+		},
+	}
+
+	if dryRun {
+		return secret, nil
+	}
+	return clientset.Core().Secrets(namespace).Create(secret)
+}
+
+// createDatastoreCredentialsSecret reads the (optional) CA/cert/key files
+// used by the datastore shim sidecar to authenticate to an external SQL
+// --datastore-endpoint and stores them as a secret to be mounted into the
+// sidecar container. Any of the three paths may be empty.
+func createDatastoreCredentialsSecret(clientset *client.Clientset, namespace, name, caFile, certFile, keyFile string, dryRun bool) (*api.Secret, error) {
+	data := map[string][]byte{}
+	for key, path := range map[string]string{"ca.crt": caFile, "client.crt": certFile, "client.key": keyFile} {
+		if path == "" {
+			continue
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		data[key] = contents
+	}
+
+	secret := &api.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    componentLabel,
+		},
+		Data: data,
+	}
+
+	if dryRun {
+		return secret, nil
+	}
+	return clientset.Core().Secrets(namespace).Create(secret)
+}
+
 func createControllerManagerKubeconfigSecret(clientset *client.Clientset, namespace, name, svcName, kubeconfigName string, entKeyPairs *entityKeyPairs, dryRun bool) (*api.Secret, error) {
 	config := kubeadmkubeconfigphase.MakeClientConfigWithCerts(
 		fmt.Sprintf("https://%s", svcName),
@@ -416,57 +914,188 @@ func createControllerManagerKubeconfigSecret(clientset *client.Clientset, namesp
 	return util.CreateKubeconfigSecret(clientset, config, namespace, kubeconfigName, dryRun)
 }
 
-func createPVC(clientset *client.Clientset, namespace, svcName, etcdPVCapacity string, dryRun bool) (*api.PersistentVolumeClaim, error) {
+// createEtcdService exposes a headless Service fronting the etcd
+// StatefulSet so that each member gets a predictable, individually
+// addressable DNS name of the form "<etcdName>-<ordinal>.<etcdName>".
+func createEtcdService(clientset *client.Clientset, namespace, etcdName string, dryRun bool) (*api.Service, error) {
+	svc := &api.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      etcdName,
+			Namespace: namespace,
+			Labels:    componentLabel,
+		},
+		Spec: api.ServiceSpec{
+			ClusterIP: api.ClusterIPNone,
+			Selector:  etcdSvcSelector,
+			Ports: []api.ServicePort{
+				{
+					Name:       "client",
+					Protocol:   "TCP",
+					Port:       2379,
+					TargetPort: intstr.FromInt(2379),
+				},
+				{
+					Name:       "peer",
+					Protocol:   "TCP",
+					Port:       2380,
+					TargetPort: intstr.FromInt(2380),
+				},
+			},
+		},
+	}
+
+	if dryRun {
+		return svc, nil
+	}
+
+	return clientset.Core().Services(namespace).Create(svc)
+}
+
+// etcdPodDNSNames returns the predictable per-replica DNS names that the
+// etcd headless Service guarantees for each StatefulSet pod, e.g.
+// "foo-etcd-0.foo-etcd.federation-system.svc.cluster.local.".
+func etcdPodDNSNames(etcdSvcName, namespace, localDNSZoneName string, replicas int32) []string {
+	names := []string{}
+	for i := int32(0); i < replicas; i++ {
+		names = append(names, fmt.Sprintf("%s-%d.%s.%s.svc.%s", etcdSvcName, i, etcdSvcName, namespace, localDNSZoneName))
+	}
+	return names
+}
+
+// createEtcdCluster creates a StatefulSet running a dedicated etcd
+// cluster for the federation API server, with one PVC per replica
+// derived from etcdPVCapacity.
+func createEtcdCluster(clientset *client.Clientset, namespace, etcdName, etcdPVCapacity string, replicas int32, dryRun bool) (*apps.StatefulSet, error) {
 	capacity, err := resource.ParseQuantity(etcdPVCapacity)
 	if err != nil {
 		return nil, err
 	}
 
-	pvc := &api.PersistentVolumeClaim{
+	initialCluster := []string{}
+	for i := int32(0); i < replicas; i++ {
+		initialCluster = append(initialCluster, fmt.Sprintf("etcd-%d=http://%s-%d.%s.%s.svc.%s:2380", i, etcdName, i, etcdName, namespace, HostClusterLocalDNSZoneName))
+	}
+
+	dataVolumeName := "etcddata"
+	set := &apps.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-etcd-claim", svcName),
+			Name:      etcdName,
 			Namespace: namespace,
 			Labels:    componentLabel,
-			Annotations: map[string]string{
-				"volume.alpha.kubernetes.io/storage-class": "yes",
-			},
 		},
-		Spec: api.PersistentVolumeClaimSpec{
-			AccessModes: []api.PersistentVolumeAccessMode{
-				api.ReadWriteOnce,
+		Spec: apps.StatefulSetSpec{
+			ServiceName: etcdName,
+			Replicas:    replicas,
+			Template: api.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   etcdName,
+					Labels: etcdPodLabels,
+				},
+				Spec: api.PodSpec{
+					Containers: []api.Container{
+						{
+							Name:  "etcd",
+							Image: "gcr.io/google_containers/etcd:3.0.14-alpha.1",
+							Command: []string{
+								"/usr/local/bin/etcd",
+								"--data-dir", "/var/etcd/data",
+								"--listen-client-urls", "http://0.0.0.0:2379",
+								"--listen-peer-urls", "http://0.0.0.0:2380",
+								"--advertise-client-urls", fmt.Sprintf("http://$(POD_NAME).%s.%s.svc.%s:2379", etcdName, namespace, HostClusterLocalDNSZoneName),
+								"--initial-advertise-peer-urls", fmt.Sprintf("http://$(POD_NAME).%s.%s.svc.%s:2380", etcdName, namespace, HostClusterLocalDNSZoneName),
+								"--initial-cluster", strings.Join(initialCluster, ","),
+								"--initial-cluster-state", "new",
+							},
+							Env: []api.EnvVar{
+								{
+									Name: "POD_NAME",
+									ValueFrom: &api.EnvVarSource{
+										FieldRef: &api.ObjectFieldSelector{
+											FieldPath: "metadata.name",
+										},
+									},
+								},
+							},
+							VolumeMounts: []api.VolumeMount{
+								{
+									Name:      dataVolumeName,
+									MountPath: "/var/etcd",
+								},
+							},
+						},
+					},
+				},
 			},
-			Resources: api.ResourceRequirements{
-				Requests: api.ResourceList{
-					api.ResourceStorage: capacity,
+			VolumeClaimTemplates: []api.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   dataVolumeName,
+						Labels: componentLabel,
+					},
+					Spec: api.PersistentVolumeClaimSpec{
+						AccessModes: []api.PersistentVolumeAccessMode{
+							api.ReadWriteOnce,
+						},
+						Resources: api.ResourceRequirements{
+							Requests: api.ResourceList{
+								api.ResourceStorage: capacity,
+							},
+						},
+					},
 				},
 			},
 		},
 	}
 
 	if dryRun {
-		return pvc, nil
+		return set, nil
 	}
 
-	return clientset.Core().PersistentVolumeClaims(namespace).Create(pvc)
+	return clientset.Apps().StatefulSets(namespace).Create(set)
 }
 
-func createAPIServer(clientset *client.Clientset, namespace, name, image, credentialsName, advertiseAddress, storageBackend string, pvc *api.PersistentVolumeClaim, dryRun bool) (*extensions.Deployment, error) {
+// createAPIServer builds the federation-apiserver Deployment. When
+// etcdServers is empty and no datastoreSidecar is supplied, a single
+// ephemeral etcd instance is colocated in the pod (no persistence, no
+// HA) to preserve the zero-config quickstart experience. Otherwise the
+// apiserver talks to the given etcdServers directly, and datastoreSidecar
+// (if non-nil) is added as an extra container fronting them, e.g. a
+// datastore shim translating a SQL --datastore-endpoint to etcd v3 gRPC
+// on 127.0.0.1.
+func createAPIServer(clientset *client.Clientset, namespace, name, image, credentialsName, advertiseAddress, storageBackend string, etcdServers []string, datastoreSidecar *api.Container, datastoreSidecarVolume *api.Volume, extraArgs []string, replicas int32, dryRun bool) (*extensions.Deployment, error) {
+	var localEtcdSidecar *api.Container
+	if len(etcdServers) == 0 && datastoreSidecar == nil {
+		etcdServers = []string{"http://localhost:2379"}
+		localEtcdSidecar = &api.Container{
+			Name:  "etcd",
+			Image: "gcr.io/google_containers/etcd:3.0.14-alpha.1",
+			Command: []string{
+				"/usr/local/bin/etcd",
+				"--data-dir",
+				"/var/etcd/data",
+			},
+		}
+	}
+
 	command := []string{
 		"/hyperkube",
 		"federation-apiserver",
 		"--bind-address=0.0.0.0",
-		"--etcd-servers=http://localhost:2379",
+		fmt.Sprintf("--etcd-servers=%s", strings.Join(etcdServers, ",")),
 		"--secure-port=443",
 		"--client-ca-file=/etc/federation/apiserver/ca.crt",
 		"--tls-cert-file=/etc/federation/apiserver/server.crt",
 		"--tls-private-key-file=/etc/federation/apiserver/server.key",
 		"--admission-control=NamespaceLifecycle",
 		fmt.Sprintf("--storage-backend=%s", storageBackend),
+		"--token-auth-file=/etc/federation/apiserver/known_tokens.csv",
+		"--basic-auth-file=/etc/federation/apiserver/basic_auth.csv",
 	}
 
 	if advertiseAddress != "" {
 		command = append(command, fmt.Sprintf("--advertise-address=%s", advertiseAddress))
 	}
+	command = append(command, extraArgs...)
 
 	dep := &extensions.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
@@ -475,7 +1104,7 @@ func createAPIServer(clientset *client.Clientset, namespace, name, image, creden
 			Labels:    componentLabel,
 		},
 		Spec: extensions.DeploymentSpec{
-			Replicas: 1,
+			Replicas: replicas,
 			Template: api.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:   name,
@@ -505,15 +1134,6 @@ func createAPIServer(clientset *client.Clientset, namespace, name, image, creden
 								},
 							},
 						},
-						{
-							Name:  "etcd",
-							Image: "gcr.io/google_containers/etcd:3.0.14-alpha.1",
-							Command: []string{
-								"/usr/local/bin/etcd",
-								"--data-dir",
-								"/var/etcd/data",
-							},
-						},
 					},
 					Volumes: []api.Volume{
 						{
@@ -530,27 +1150,14 @@ func createAPIServer(clientset *client.Clientset, namespace, name, image, creden
 		},
 	}
 
-	if pvc != nil {
-		dataVolumeName := "etcddata"
-		etcdVolume := api.Volume{
-			Name: dataVolumeName,
-			VolumeSource: api.VolumeSource{
-				PersistentVolumeClaim: &api.PersistentVolumeClaimVolumeSource{
-					ClaimName: pvc.Name,
-				},
-			},
-		}
-		etcdVolumeMount := api.VolumeMount{
-			Name:      dataVolumeName,
-			MountPath: "/var/etcd",
-		}
-
-		dep.Spec.Template.Spec.Volumes = append(dep.Spec.Template.Spec.Volumes, etcdVolume)
-		for i, container := range dep.Spec.Template.Spec.Containers {
-			if container.Name == "etcd" {
-				dep.Spec.Template.Spec.Containers[i].VolumeMounts = append(dep.Spec.Template.Spec.Containers[i].VolumeMounts, etcdVolumeMount)
-			}
-		}
+	if localEtcdSidecar != nil {
+		dep.Spec.Template.Spec.Containers = append(dep.Spec.Template.Spec.Containers, *localEtcdSidecar)
+	}
+	if datastoreSidecar != nil {
+		dep.Spec.Template.Spec.Containers = append(dep.Spec.Template.Spec.Containers, *datastoreSidecar)
+	}
+	if datastoreSidecarVolume != nil {
+		dep.Spec.Template.Spec.Volumes = append(dep.Spec.Template.Spec.Volumes, *datastoreSidecarVolume)
 	}
 
 	if dryRun {
@@ -608,7 +1215,28 @@ func createRoleBindings(clientset *client.Clientset, namespace, saName string, d
 	return newRole, newRolebinding, err
 }
 
-func createControllerManager(clientset *client.Clientset, namespace, name, svcName, cmName, image, kubeconfigName, dnsZoneName, dnsProvider, saName string, dryRun bool) (*extensions.Deployment, error) {
+func createControllerManager(clientset *client.Clientset, namespace, name, svcName, cmName, image, kubeconfigName, dnsZoneName, dnsProvider, saName, leaseNamespace string, disableControllers, extraArgs []string, replicas int32, dryRun bool) (*extensions.Deployment, error) {
+	command := []string{
+		"/hyperkube",
+		"federation-controller-manager",
+		fmt.Sprintf("--master=https://%s", svcName),
+		"--kubeconfig=/etc/federation/controller-manager/kubeconfig",
+		fmt.Sprintf("--dns-provider=%s", dnsProvider),
+		"--dns-provider-config=",
+		fmt.Sprintf("--federation-name=%s", name),
+		fmt.Sprintf("--zone-name=%s", dnsZoneName),
+		"--leader-elect=true",
+		fmt.Sprintf("--lease-namespace=%s", leaseNamespace),
+	}
+	if len(disableControllers) > 0 {
+		disabled := make([]string, 0, len(disableControllers))
+		for _, controller := range disableControllers {
+			disabled = append(disabled, fmt.Sprintf("-%s", controller))
+		}
+		command = append(command, fmt.Sprintf("--controllers=%s", strings.Join(disabled, ",")))
+	}
+	command = append(command, extraArgs...)
+
 	dep := &extensions.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      cmName,
@@ -616,7 +1244,7 @@ func createControllerManager(clientset *client.Clientset, namespace, name, svcNa
 			Labels:    componentLabel,
 		},
 		Spec: extensions.DeploymentSpec{
-			Replicas: 1,
+			Replicas: replicas,
 			Template: api.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:   cmName,
@@ -625,18 +1253,9 @@ func createControllerManager(clientset *client.Clientset, namespace, name, svcNa
 				Spec: api.PodSpec{
 					Containers: []api.Container{
 						{
-							Name:  "controller-manager",
-							Image: image,
-							Command: []string{
-								"/hyperkube",
-								"federation-controller-manager",
-								fmt.Sprintf("--master=https://%s", svcName),
-								"--kubeconfig=/etc/federation/controller-manager/kubeconfig",
-								fmt.Sprintf("--dns-provider=%s", dnsProvider),
-								"--dns-provider-config=",
-								fmt.Sprintf("--federation-name=%s", name),
-								fmt.Sprintf("--zone-name=%s", dnsZoneName),
-							},
+							Name:    "controller-manager",
+							Image:   image,
+							Command: command,
 							VolumeMounts: []api.VolumeMount{
 								{
 									Name:      kubeconfigName,
@@ -678,29 +1297,63 @@ func createControllerManager(clientset *client.Clientset, namespace, name, svcNa
 	return clientset.Extensions().Deployments(namespace).Create(dep)
 }
 
-func waitForPods(clientset *client.Clientset, fedPods []string, namespace string) error {
+// podComponent names a Deployment-managed federation component and the
+// number of Running pods it needs before waitForPods considers it ready.
+type podComponent struct {
+	name     string
+	replicas int32
+}
+
+// waitForPods waits until each component in fedComponents has at least
+// as many Running pods as its replicas, and, when etcdQuorum is greater
+// than zero, until at least that many pods of the etcdName StatefulSet
+// are running. This prevents the apiserver from being considered
+// healthy before its backing etcd cluster has quorum.
+func waitForPods(clientset *client.Clientset, fedComponents []podComponent, namespace, etcdName string, etcdQuorum int32) error {
 	err := wait.PollInfinite(podWaitInterval, func() (bool, error) {
-		podCheck := len(fedPods)
 		podList, err := clientset.Core().Pods(namespace).List(metav1.ListOptions{})
 		if err != nil {
 			return false, nil
 		}
-		for _, pod := range podList.Items {
-			for _, fedPod := range fedPods {
-				if strings.HasPrefix(pod.Name, fedPod) && pod.Status.Phase == "Running" {
-					podCheck -= 1
-				}
-			}
-			//ensure that all pods are in running state or keep waiting
-			if podCheck == 0 {
-				return true, nil
-			}
-		}
-		return false, nil
+		return podsReady(podList, fedComponents, etcdName, etcdQuorum), nil
 	})
 	return err
 }
 
+// podsReady reports whether podList shows at least etcdQuorum Running
+// pods whose name has the etcdName prefix, and, for every component in
+// fedComponents, at least comp.replicas Running pods whose name has the
+// comp.name prefix. Split out of waitForPods so the counting logic can
+// be table-tested without a fake clientset.
+func podsReady(podList *api.PodList, fedComponents []podComponent, etcdName string, etcdQuorum int32) bool {
+	running := make(map[string]int32, len(fedComponents))
+	etcdRunning := int32(0)
+	for _, pod := range podList.Items {
+		if pod.Status.Phase != "Running" {
+			continue
+		}
+		if strings.HasPrefix(pod.Name, etcdName) {
+			etcdRunning++
+			continue
+		}
+		for _, comp := range fedComponents {
+			if strings.HasPrefix(pod.Name, comp.name) {
+				running[comp.name]++
+				break
+			}
+		}
+	}
+	if etcdRunning < etcdQuorum {
+		return false
+	}
+	for _, comp := range fedComponents {
+		if running[comp.name] < comp.replicas {
+			return false
+		}
+	}
+	return true
+}
+
 func waitSrvHealthy(config util.AdminConfig, context, kubeconfig string) error {
 	fedClientSet, err := config.FederationClientset(context, kubeconfig)
 	if err != nil {
@@ -720,13 +1373,24 @@ func waitSrvHealthy(config util.AdminConfig, context, kubeconfig string) error {
 	return err
 }
 
-func printSuccess(cmdOut io.Writer, ips, hostnames []string) error {
-	svcEndpoints := append(ips, hostnames...)
+func printSuccess(cmdOut io.Writer, ips, hostnames []string, serviceType api.ServiceType, nodePort int32, joinToken string) error {
+	port := int32(443)
+	if serviceType == api.ServiceTypeNodePort && nodePort != 0 {
+		port = nodePort
+	}
+	svcEndpoints := append(append([]string{}, ips...), hostnames...)
+	for i, endpoint := range svcEndpoints {
+		svcEndpoints[i] = fmt.Sprintf("https://%s:%d", endpoint, port)
+	}
 	_, err := fmt.Fprintf(cmdOut, "Federation API server is running at: %s\n", strings.Join(svcEndpoints, ", "))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cmdOut, "Use the following token with `kubefed join` to authenticate without distributing the CA key: %s\n", joinToken)
 	return err
 }
 
-func updateKubeconfig(config util.AdminConfig, name, endpoint string, entKeyPairs *entityKeyPairs, dryRun bool) error {
+func updateKubeconfig(config util.AdminConfig, name, endpoint string, entKeyPairs *entityKeyPairs, auth *authFiles, authMode string, dryRun bool) error {
 	po := config.PathOptions()
 	kubeconfig, err := po.GetStartingConfig()
 	if err != nil {
@@ -742,11 +1406,19 @@ func updateKubeconfig(config util.AdminConfig, name, endpoint string, entKeyPair
 	cluster.Server = endpoint
 	cluster.CertificateAuthorityData = certutil.EncodeCertPEM(entKeyPairs.ca.Cert)
 
-	// Populate credentials.
+	// Populate credentials according to the requested --auth-mode.
 	authInfo := clientcmdapi.NewAuthInfo()
-	authInfo.ClientCertificateData = certutil.EncodeCertPEM(entKeyPairs.admin.Cert)
-	authInfo.ClientKeyData = certutil.EncodePrivateKeyPEM(entKeyPairs.admin.Key)
-	authInfo.Username = AdminCN
+	switch authMode {
+	case "token":
+		authInfo.Token = auth.adminToken
+	case "basic":
+		authInfo.Username = AdminCN
+		authInfo.Password = auth.adminPassword
+	default:
+		authInfo.ClientCertificateData = certutil.EncodeCertPEM(entKeyPairs.admin.Cert)
+		authInfo.ClientKeyData = certutil.EncodePrivateKeyPEM(entKeyPairs.admin.Key)
+		authInfo.Username = AdminCN
+	}
 
 	// Populate context.
 	context := clientcmdapi.NewContext()
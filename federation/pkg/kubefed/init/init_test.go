@@ -0,0 +1,193 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package init
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestGenAuthFiles(t *testing.T) {
+	auth, err := genAuthFiles([]string{"extra-user:group1,group2"})
+	if err != nil {
+		t.Fatalf("genAuthFiles returned error: %v", err)
+	}
+
+	knownTokens := string(auth.knownTokens)
+	for _, user := range []string{AdminCN, ControllerManagerCN, "extra-user", JoinTokenUser} {
+		if !strings.Contains(knownTokens, ","+user+",") {
+			t.Errorf("known_tokens.csv missing entry for %q:\n%s", user, knownTokens)
+		}
+	}
+	if !strings.Contains(knownTokens, "\"group1,group2\"") {
+		t.Errorf("known_tokens.csv missing quoted groups for extra-user:\n%s", knownTokens)
+	}
+
+	basicAuth := string(auth.basicAuth)
+	for _, user := range []string{AdminCN, ControllerManagerCN, "extra-user"} {
+		if !strings.Contains(basicAuth, ","+user+",") {
+			t.Errorf("basic_auth.csv missing entry for %q:\n%s", user, basicAuth)
+		}
+	}
+
+	if auth.adminToken == "" || auth.adminPassword == "" {
+		t.Error("expected non-empty admin token and password")
+	}
+	if auth.joinToken == "" {
+		t.Error("expected non-empty join token")
+	}
+}
+
+func TestExtraArgsToFlags(t *testing.T) {
+	got := extraArgsToFlags([]string{"foo=bar", "baz=qux"})
+	want := []string{"--foo=bar", "--baz=qux"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("extraArgsToFlags() = %v, want %v", got, want)
+	}
+}
+
+func TestSplitAdvertiseAddress(t *testing.T) {
+	ips, hostnames := splitAdvertiseAddress("10.0.0.1, example.com ,10.0.0.2,")
+	if !reflect.DeepEqual(ips, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("ips = %v", ips)
+	}
+	if !reflect.DeepEqual(hostnames, []string{"example.com"}) {
+		t.Errorf("hostnames = %v", hostnames)
+	}
+}
+
+func TestJoinSANs(t *testing.T) {
+	got := JoinSANs([]string{"10.0.0.2", "10.0.0.1"}, []string{"b.example.com", "a.example.com"})
+	want := "10.0.0.1,10.0.0.2,a.example.com,b.example.com"
+	if got != want {
+		t.Errorf("JoinSANs() = %q, want %q", got, want)
+	}
+}
+
+func TestNodeExternalOrInternalIP(t *testing.T) {
+	node := &api.Node{
+		Status: api.NodeStatus{
+			Addresses: []api.NodeAddress{
+				{Type: api.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: api.NodeExternalIP, Address: "1.2.3.4"},
+			},
+		},
+	}
+	if got := nodeExternalOrInternalIP(node); got != "1.2.3.4" {
+		t.Errorf("nodeExternalOrInternalIP() = %q, want ExternalIP", got)
+	}
+
+	internalOnly := &api.Node{
+		Status: api.NodeStatus{
+			Addresses: []api.NodeAddress{
+				{Type: api.NodeInternalIP, Address: "10.0.0.1"},
+			},
+		},
+	}
+	if got := nodeExternalOrInternalIP(internalOnly); got != "10.0.0.1" {
+		t.Errorf("nodeExternalOrInternalIP() = %q, want InternalIP fallback", got)
+	}
+}
+
+func TestEtcdPodDNSNames(t *testing.T) {
+	got := etcdPodDNSNames("foo-etcd", "federation-system", "cluster.local.", 3)
+	want := []string{
+		"foo-etcd-0.foo-etcd.federation-system.svc.cluster.local.",
+		"foo-etcd-1.foo-etcd.federation-system.svc.cluster.local.",
+		"foo-etcd-2.foo-etcd.federation-system.svc.cluster.local.",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("etcdPodDNSNames() = %v, want %v", got, want)
+	}
+}
+
+func runningPod(name string) api.Pod {
+	return api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     api.PodStatus{Phase: api.PodRunning},
+	}
+}
+
+func TestPodsReady(t *testing.T) {
+	apiserver := podComponent{name: "foo-apiserver", replicas: 2}
+	controllerManager := podComponent{name: "foo-controller-manager", replicas: 1}
+	components := []podComponent{apiserver, controllerManager}
+
+	tests := []struct {
+		name       string
+		pods       []api.Pod
+		etcdQuorum int32
+		want       bool
+	}{
+		{
+			name: "etcd pods never satisfy the apiserver component, even as a name prefix",
+			pods: []api.Pod{
+				runningPod("foo-apiserver-etcd-0"),
+				runningPod("foo-apiserver-etcd-1"),
+			},
+			etcdQuorum: 2,
+			want:       false,
+		},
+		{
+			name: "one apiserver replica running is not enough when two are required",
+			pods: []api.Pod{
+				runningPod("foo-apiserver-111"),
+				runningPod("foo-controller-manager-222"),
+			},
+			etcdQuorum: 0,
+			want:       false,
+		},
+		{
+			name: "all components and etcd quorum satisfied",
+			pods: []api.Pod{
+				runningPod("foo-apiserver-111"),
+				runningPod("foo-apiserver-222"),
+				runningPod("foo-controller-manager-333"),
+				runningPod("foo-apiserver-etcd-0"),
+				runningPod("foo-apiserver-etcd-1"),
+			},
+			etcdQuorum: 2,
+			want:       true,
+		},
+		{
+			name: "non-running pods don't count",
+			pods: []api.Pod{
+				runningPod("foo-apiserver-111"),
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "foo-apiserver-222"},
+					Status:     api.PodStatus{Phase: api.PodPending},
+				},
+				runningPod("foo-controller-manager-333"),
+			},
+			etcdQuorum: 0,
+			want:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			podList := &api.PodList{Items: tt.pods}
+			if got := podsReady(podList, components, "foo-apiserver-etcd", tt.etcdQuorum); got != tt.want {
+				t.Errorf("podsReady() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
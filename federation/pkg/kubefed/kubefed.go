@@ -0,0 +1,52 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kubefed implements the `kubefed` command line tool used to
+// bootstrap and manage a federation control plane.
+package kubefed
+
+import (
+	"io"
+
+	kubefedinit "k8s.io/kubernetes/federation/pkg/kubefed/init"
+	"k8s.io/kubernetes/federation/pkg/kubefed/rotatecerts"
+	"k8s.io/kubernetes/federation/pkg/kubefed/util"
+	"k8s.io/kubernetes/pkg/kubectl/cmd/templates"
+
+	"github.com/spf13/cobra"
+)
+
+var kubefedLong = templates.LongDesc(`
+	kubefed manages the lifecycle of a federation control plane hosted
+	inside a Kubernetes cluster.`)
+
+// NewKubefedCommand creates the `kubefed` root command and wires up its
+// subcommands.
+func NewKubefedCommand(cmdOut io.Writer, config util.AdminConfig) *cobra.Command {
+	cmds := &cobra.Command{
+		Use:   "kubefed",
+		Short: "kubefed manages a federation control plane",
+		Long:  kubefedLong,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	cmds.AddCommand(kubefedinit.NewCmdInit(cmdOut, config))
+	cmds.AddCommand(rotatecerts.NewCmdRotateCerts(cmdOut, config))
+
+	return cmds
+}
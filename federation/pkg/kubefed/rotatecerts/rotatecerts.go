@@ -0,0 +1,312 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rotatecerts implements the `kubefed rotate-certs` command. It
+// re-signs the federation apiserver's server certificate against the CA
+// persisted by `kubefed init` whenever the federation Service's
+// LoadBalancer address has drifted from the SANs the current
+// certificate was issued for, and optionally runs that reconciliation
+// continuously so it can be baked into the controller-manager image.
+package rotatecerts
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	certutil "k8s.io/client-go/util/cert"
+	triple "k8s.io/client-go/util/cert/triple"
+	kubefedinit "k8s.io/kubernetes/federation/pkg/kubefed/init"
+	"k8s.io/kubernetes/federation/pkg/kubefed/util"
+	"k8s.io/kubernetes/pkg/api"
+	client "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
+	"k8s.io/kubernetes/pkg/kubectl/cmd/templates"
+	cmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// DeploymentRestartAnnotation is bumped on the apiserver Deployment's
+	// pod template on every rotation to force a rolling restart so that
+	// every replica picks up the freshly signed certificate.
+	DeploymentRestartAnnotation = "federation.alpha.kubernetes.io/cert-rotated-at"
+)
+
+var (
+	rotateCertsLong = templates.LongDesc(`
+		Re-sign the federation apiserver's server certificate.
+
+        If the federation Service's LoadBalancer address has changed, or
+        if the --cert-validity interval passed to kubefed init has
+        elapsed since the certificate currently in the
+        "<federation-name>-credentials" secret was issued, a new
+        certificate is signed against the CA persisted in the
+        "<federation-name>-ca" secret (created by kubefed init) and the
+        apiserver Deployment is rolled to pick it up. Rotation refuses to
+        proceed if the CA secret is missing.`)
+	rotateCertsExample = templates.Examples(`
+		# Rotate the federation-apiserver certificate for a federation
+		# named foo once, if its LoadBalancer address has changed.
+		kubefed rotate-certs foo --host-cluster-context=bar
+
+		# Run continuously, e.g. from within the controller-manager image.
+		kubefed rotate-certs foo --host-cluster-context=bar --watch`)
+)
+
+// NewCmdRotateCerts defines the `rotate-certs` command.
+func NewCmdRotateCerts(cmdOut io.Writer, config util.AdminConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "rotate-certs FEDERATION_NAME --host-cluster-context=HOST_CONTEXT",
+		Short:   "rotate-certs re-signs the federation apiserver's server certificate",
+		Long:    rotateCertsLong,
+		Example: rotateCertsExample,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := rotateCerts(cmdOut, config, cmd, args)
+			cmdutil.CheckErr(err)
+		},
+	}
+
+	util.AddSubcommandFlags(cmd)
+	cmd.Flags().Bool("watch", false, "Run continuously, watching the federation Service for LoadBalancer address changes instead of rotating once and exiting.")
+	return cmd
+}
+
+func rotateCerts(cmdOut io.Writer, config util.AdminConfig, cmd *cobra.Command, args []string) error {
+	initFlags, err := util.GetSubcommandFlags(cmd, args)
+	if err != nil {
+		return err
+	}
+	watchMode := cmdutil.GetFlagBool(cmd, "watch")
+
+	hostFactory := config.HostFactory(initFlags.Host, initFlags.Kubeconfig)
+	hostClientset, err := hostFactory.ClientSet()
+	if err != nil {
+		return err
+	}
+
+	if !watchMode {
+		rotated, err := rotateOnce(hostClientset, initFlags.FederationSystemNamespace, initFlags.Name)
+		if err != nil {
+			return err
+		}
+		if rotated {
+			_, err = fmt.Fprintf(cmdOut, "Rotated federation apiserver server certificate for %q\n", initFlags.Name)
+		} else {
+			_, err = fmt.Fprintf(cmdOut, "Federation apiserver server certificate for %q is already up to date\n", initFlags.Name)
+		}
+		return err
+	}
+
+	return watchAndRotate(cmdOut, hostClientset, initFlags.FederationSystemNamespace, initFlags.Name)
+}
+
+// watchAndRotate watches the federation apiserver Service and re-runs
+// rotateOnce whenever it is added or modified, e.g. when the cloud
+// provider assigns a new LoadBalancer address. It only returns when the
+// watch itself fails or is closed by the apiserver; individual rotation
+// failures are logged and do not stop the watch.
+func watchAndRotate(cmdOut io.Writer, clientset *client.Clientset, namespace, name string) error {
+	svcName := kubefedinit.ServerName(name)
+	fmt.Fprintf(cmdOut, "Watching %q for LoadBalancer address changes...\n", svcName)
+
+	w, err := clientset.Core().Services(namespace).Watch(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", svcName).String(),
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	for event := range w.ResultChan() {
+		if event.Type != watch.Added && event.Type != watch.Modified {
+			continue
+		}
+		rotated, err := rotateOnce(clientset, namespace, name)
+		if err != nil {
+			utilruntime.HandleError(fmt.Errorf("certificate rotation for %q failed: %v", name, err))
+			continue
+		}
+		if rotated {
+			fmt.Fprintf(cmdOut, "Rotated federation apiserver server certificate for %q\n", name)
+		}
+	}
+	return fmt.Errorf("watch on service %q closed", svcName)
+}
+
+// rotateOnce re-signs the server certificate if the federation Service's
+// current LoadBalancer SANs differ from the ones recorded on the
+// credentials secret, or if --cert-validity (recorded on the CA secret
+// by `kubefed init`) has elapsed since the certificate was last signed,
+// and returns whether a rotation happened.
+func rotateOnce(clientset *client.Clientset, namespace, name string) (bool, error) {
+	caSecretName := kubefedinit.CASecretName(name)
+	caSecret, err := clientset.Core().Secrets(namespace).Get(caSecretName, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("refusing to rotate certificates: CA secret %q not found: %v", caSecretName, err)
+	}
+	ca, err := loadCAKeyPair(caSecret.Data["ca.crt"], caSecret.Data["ca.key"])
+	if err != nil {
+		return false, err
+	}
+
+	serverName := kubefedinit.ServerName(name)
+	svc, err := clientset.Core().Services(namespace).Get(serverName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	var ips, hostnames []string
+	for _, ing := range svc.Status.LoadBalancer.Ingress {
+		if len(ing.IP) > 0 {
+			ips = append(ips, ing.IP)
+		}
+		if len(ing.Hostname) > 0 {
+			hostnames = append(hostnames, ing.Hostname)
+		}
+	}
+
+	credName := kubefedinit.CredentialsSecretName(name)
+	credSecret, err := clientset.Core().Secrets(namespace).Get(credName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	newSANs := kubefedinit.JoinSANs(ips, hostnames)
+	sansChanged := credSecret.Annotations[kubefedinit.ApiserverSANsAnnotation] != newSANs
+	if !sansChanged && !certValidityElapsed(caSecret, credSecret) {
+		return false, nil
+	}
+
+	// Keep the previous server certificate valid through the overlap
+	// window by including both the old and new SANs in the freshly
+	// signed certificate.
+	oldIPs, oldHostnames := splitSANs(credSecret.Annotations[kubefedinit.ApiserverSANsAnnotation])
+	overlapIPs := mergeUnique(oldIPs, ips)
+	overlapHostnames := mergeUnique(oldHostnames, hostnames)
+
+	server, err := triple.NewServerKeyPair(ca, kubefedinit.APIServerCN, svc.Name, namespace, kubefedinit.HostClusterLocalDNSZoneName, overlapIPs, overlapHostnames)
+	if err != nil {
+		return false, fmt.Errorf("failed to re-sign federation apiserver server certificate: %v", err)
+	}
+
+	if credSecret.Annotations == nil {
+		credSecret.Annotations = map[string]string{}
+	}
+	credSecret.Annotations[kubefedinit.ApiserverSANsAnnotation] = newSANs
+	credSecret.Annotations[kubefedinit.ServerCertSignedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	credSecret.Data["server.crt"] = certutil.EncodeCertPEM(server.Cert)
+	credSecret.Data["server.key"] = certutil.EncodePrivateKeyPEM(server.Key)
+	updated, err := clientset.Core().Secrets(namespace).Update(credSecret)
+	if err != nil {
+		return false, err
+	}
+
+	return true, triggerRollingRestart(clientset, namespace, serverName, updated.ResourceVersion)
+}
+
+// triggerRollingRestart bumps an annotation on the apiserver Deployment's
+// pod template so that the deployment controller rolls every replica,
+// picking up the newly signed certificate.
+func triggerRollingRestart(clientset *client.Clientset, namespace, deploymentName, marker string) error {
+	dep, err := clientset.Extensions().Deployments(namespace).Get(deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if dep.Spec.Template.Annotations == nil {
+		dep.Spec.Template.Annotations = map[string]string{}
+	}
+	dep.Spec.Template.Annotations[DeploymentRestartAnnotation] = marker
+	_, err = clientset.Extensions().Deployments(namespace).Update(dep)
+	return err
+}
+
+// splitSANs reverses kubefedinit.JoinSANs, classifying each entry as an IP or a
+// hostname by whether it parses as a net.IP.
+func splitSANs(joined string) (ips, hostnames []string) {
+	if joined == "" {
+		return nil, nil
+	}
+	for _, san := range strings.Split(joined, ",") {
+		if net.ParseIP(san) != nil {
+			ips = append(ips, san)
+		} else {
+			hostnames = append(hostnames, san)
+		}
+	}
+	return ips, hostnames
+}
+
+// certValidityElapsed reports whether the --cert-validity interval
+// recorded on the CA secret has elapsed since the server certificate in
+// credSecret was last signed. Secrets from before --cert-validity
+// existed, or that otherwise carry no parseable annotation, are treated
+// as not yet due for proactive rotation.
+func certValidityElapsed(caSecret, credSecret *api.Secret) bool {
+	validityStr := caSecret.Annotations[kubefedinit.CertValidityAnnotation]
+	signedAtStr := credSecret.Annotations[kubefedinit.ServerCertSignedAtAnnotation]
+	if validityStr == "" || signedAtStr == "" {
+		return false
+	}
+	validity, err := time.ParseDuration(validityStr)
+	if err != nil {
+		return false
+	}
+	signedAt, err := time.Parse(time.RFC3339, signedAtStr)
+	if err != nil {
+		return false
+	}
+	return time.Since(signedAt) >= validity
+}
+
+func mergeUnique(a, b []string) []string {
+	seen := map[string]bool{}
+	merged := []string{}
+	for _, s := range append(append([]string{}, a...), b...) {
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+func loadCAKeyPair(certPEM, keyPEM []byte) (*triple.KeyPair, error) {
+	certs, err := certutil.ParseCertsPEM(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("CA secret contains no certificate")
+	}
+	keyIface, err := certutil.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %v", err)
+	}
+	key, ok := keyIface.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("CA key is not an RSA private key")
+	}
+	return &triple.KeyPair{Cert: certs[0], Key: key}, nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rotatecerts
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefedinit "k8s.io/kubernetes/federation/pkg/kubefed/init"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestSplitSANs(t *testing.T) {
+	ips, hostnames := splitSANs(kubefedinit.JoinSANs([]string{"10.0.0.1", "10.0.0.2"}, []string{"example.com"}))
+	if !reflect.DeepEqual(ips, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("ips = %v", ips)
+	}
+	if !reflect.DeepEqual(hostnames, []string{"example.com"}) {
+		t.Errorf("hostnames = %v", hostnames)
+	}
+
+	if ips, hostnames := splitSANs(""); ips != nil || hostnames != nil {
+		t.Errorf("splitSANs(\"\") = %v, %v, want nil, nil", ips, hostnames)
+	}
+}
+
+func TestMergeUnique(t *testing.T) {
+	got := mergeUnique([]string{"a", "b", ""}, []string{"b", "c"})
+	want := []string{"a", "b", "c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeUnique() = %v, want %v", got, want)
+	}
+}
+
+func secretWithAnnotations(annotations map[string]string) *api.Secret {
+	return &api.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}
+}
+
+func TestCertValidityElapsed(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		ca   *api.Secret
+		cred *api.Secret
+		want bool
+	}{
+		{
+			name: "missing annotations are treated as not due",
+			ca:   secretWithAnnotations(nil),
+			cred: secretWithAnnotations(nil),
+			want: false,
+		},
+		{
+			name: "validity not yet elapsed",
+			ca:   secretWithAnnotations(map[string]string{kubefedinit.CertValidityAnnotation: (24 * time.Hour).String()}),
+			cred: secretWithAnnotations(map[string]string{kubefedinit.ServerCertSignedAtAnnotation: now.Add(-time.Hour).UTC().Format(time.RFC3339)}),
+			want: false,
+		},
+		{
+			name: "validity elapsed",
+			ca:   secretWithAnnotations(map[string]string{kubefedinit.CertValidityAnnotation: time.Hour.String()}),
+			cred: secretWithAnnotations(map[string]string{kubefedinit.ServerCertSignedAtAnnotation: now.Add(-2 * time.Hour).UTC().Format(time.RFC3339)}),
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := certValidityElapsed(tt.ca, tt.cred); got != tt.want {
+				t.Errorf("certValidityElapsed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}